@@ -3,11 +3,8 @@ package apt
 import (
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
-	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
 )
@@ -49,13 +46,36 @@ type Apt struct {
 	Keys               []string     `yaml:"keys"`
 	GpgAdvancedOptions []string     `yaml:"gpg_advanced_options"`
 	Repos              []Repository `yaml:"repos"`
-	Packages           []string     `yaml:"packages"`
+	Packages           []Package    `yaml:"packages"`
+	Snapshot           string       `yaml:"snapshot"`
+	Architectures      []string     `yaml:"architectures"`
 	cacheDir           string
+	baseCacheDir       string
 	stateDir           string
 	sourceList         string
 	trustedKeys        string
 	installDir         string
 	preferences        string
+	indices            map[releaseIndexKey]packageIndex
+	snapshotHashes     map[string]string
+	dl                 *downloader
+}
+
+// downloader lazily creates the Apt's downloader so that a zero-value
+// Apt (as constructed by tests) still works, while SetDownloadProgress
+// can reach the same instance Download will use.
+func (a *Apt) downloader() *downloader {
+	if a.dl == nil {
+		a.dl = newDownloader()
+	}
+	return a.dl
+}
+
+// SetDownloadProgress directs .deb download progress to w instead of
+// discarding it, so tests can assert on downloader behavior without
+// hitting the network.
+func (a *Apt) SetDownloadProgress(w io.Writer) {
+	a.downloader().Progress = w
 }
 
 func New(command Command, aptFile, cacheDir, installDir string) *Apt {
@@ -64,13 +84,14 @@ func New(command Command, aptFile, cacheDir, installDir string) *Apt {
 	preferences := filepath.Join(cacheDir, "apt", "etc", "preferences")
 
 	return &Apt{
-		command:     command,
-		aptFilePath: aptFile,
-		cacheDir:    filepath.Join(cacheDir, "apt", "cache"),
-		stateDir:    filepath.Join(cacheDir, "apt", "state"),
-		sourceList:  sourceList,
-		trustedKeys: trustedKeys,
-		preferences: preferences,
+		command:      command,
+		aptFilePath:  aptFile,
+		cacheDir:     filepath.Join(cacheDir, "apt", "cache"),
+		baseCacheDir: cacheDir,
+		stateDir:     filepath.Join(cacheDir, "apt", "state"),
+		sourceList:   sourceList,
+		trustedKeys:  trustedKeys,
+		preferences:  preferences,
 		options: []string{
 			"-o", "debug::nolocking=true",
 			"-o", "dir::cache=" + filepath.Join(cacheDir, "apt", "cache"),
@@ -122,6 +143,14 @@ func (a *Apt) Setup() error {
 		return err
 	}
 
+	if err := a.pinSnapshot(); err != nil {
+		return err
+	}
+
+	if err := a.addArchitectures(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -150,7 +179,7 @@ func (a *Apt) AddRepos() error {
 	defer f.Close()
 
 	for _, repo := range a.Repos {
-		if _, err = f.WriteString("\n" + repo.Name); err != nil {
+		if _, err = f.WriteString("\n" + a.rewriteForSnapshot(repo.Name)); err != nil {
 			return err
 		}
 	}
@@ -177,16 +206,67 @@ func (a *Apt) Update() (string, error) {
 	return a.command.Output("/", "apt-get", args...)
 }
 
+// holdPackages marks every repo package with `hold: true` so apt-get
+// never upgrades it past the version resolved here, even if a newer one
+// later appears in the index.
+func (a *Apt) holdPackages() (string, error) {
+	for _, pkg := range a.Packages {
+		if !pkg.Hold || pkg.IsDebURL() || pkg.Name == "" {
+			continue
+		}
+		args := append(a.options, "hold", pkg.Name)
+		if out, err := a.command.Output("/", "apt-mark", args...); err != nil {
+			return out, fmt.Errorf("could not hold %s: %v", pkg.Name, err)
+		}
+	}
+	return "", nil
+}
+
+// Download fetches every configured package into a.cacheDir's archives
+// directory. Direct .deb URLs are downloaded individually, checked
+// against a SHA256 recovered from a verified repo index (see VerifyRepos)
+// or, failing that, an explicit `sha256:` field, and (when `version:` is
+// set) checked against the downloaded .deb's own control file; everything
+// else is handed to `apt-get install -d` to resolve and fetch, pinned to
+// `pkg=version` where a version constraint was given. Essential packages
+// fail the build if apt-get can't resolve them; others only warn.
 func (a *Apt) Download() (string, error) {
-	debPackages := make([]string, 0)
-	repoPackages := make([]string, 0)
+	debPackages := make([]Package, 0)
+	essentialPackages := make([]string, 0)
+	optionalPackages := make([]string, 0)
 
 	for _, pkg := range a.Packages {
-		if strings.HasSuffix(pkg, ".deb") {
+		if pkg.IsDebURL() {
 			debPackages = append(debPackages, pkg)
-		} else if pkg != "" {
-			repoPackages = append(repoPackages, pkg)
+			continue
+		}
+		if pkg.Name == "" {
+			continue
 		}
+
+		repoPkg := pkg.Name
+		if pkg.Arch != "" {
+			repoPkg += ":" + pkg.Arch
+		}
+		if version := aptGetVersion(pkg.Version); version != "" {
+			repoPkg += "=" + version
+		}
+
+		if pkg.Essential {
+			essentialPackages = append(essentialPackages, repoPkg)
+		} else {
+			optionalPackages = append(optionalPackages, repoPkg)
+		}
+	}
+
+	if a.HasRepos() {
+		if err := a.VerifyRepos(); err != nil {
+			return "", err
+		}
+	}
+
+	if out, err := a.holdPackages(); err != nil {
+		return out, err
 	}
 
 	archiveDir := filepath.Join(a.cacheDir, "archives")
@@ -194,80 +274,67 @@ func (a *Apt) Download() (string, error) {
 		return "", err
 	}
 
-	// download .deb packages individually
+	// download .deb packages in parallel, resuming/retrying as needed, and
+	// verifying each one's checksum (and version, if constrained) before
+	// it is considered downloaded
+	jobs := make([]downloadJob, 0, len(debPackages))
 	for _, pkg := range debPackages {
-		var last_mod_local time.Time
-		exists, err := libbuildpack.FileExists(filepath.Join(archiveDir, filepath.Base(pkg)))
-		if err != nil {
-			return "", err
+		sha256, ok := a.lookupSHA256ByFilename(filepath.Base(pkg.Name))
+		if !ok {
+			sha256, ok = pkg.SHA256, pkg.SHA256 != ""
 		}
-		packageFile, err := os.OpenFile(filepath.Join(archiveDir, filepath.Base(pkg)), os.O_RDWR|os.O_CREATE, os.ModePerm)
-		if err != nil {
-			return "", err
+		if !ok {
+			return "", fmt.Errorf("%s is not covered by a verified repo index; add a `sha256:` field for it in apt.yml", pkg.Name)
 		}
+		jobs = append(jobs, downloadJob{
+			URL:    pkg.Name,
+			Dest:   filepath.Join(archiveDir, filepath.Base(pkg.Name)),
+			SHA256: sha256,
+		})
+	}
 
-		if exists {
-			local_file_stat, err := packageFile.Stat()
-			if err != nil {
-				return "", err
-			}
-			last_mod_local = local_file_stat.ModTime()
-		} else {
-			last_mod_local = time.Time{}
+	if err := a.downloader().downloadAll(jobs); err != nil {
+		return "", err
+	}
+
+	for _, pkg := range debPackages {
+		if pkg.Version == "" {
+			continue
 		}
-		resp, err := http.Get(pkg)
+		path := filepath.Join(archiveDir, filepath.Base(pkg.Name))
+		info, err := a.readControl(path)
 		if err != nil {
 			return "", err
 		}
-		last_mod_remote, err := http.ParseTime(resp.Header.Get("last-modified"))
-		if err != nil { // handle ParseTime error on invalid last-modified headers
-			if _, ok := err.(*time.ParseError); ok {
-				last_mod_remote = time.Now()
-			} else {
-				return "", err
-			}
-		}
-		diff := last_mod_remote.Sub(last_mod_local)
-		if diff >= 0 {
-			if n, err := io.Copy(packageFile, resp.Body); err != nil {
-				resp.Body.Close()
-				packageFile.Close()
-				return "", err
-			} else if n < resp.ContentLength {
-				resp.Body.Close()
-				packageFile.Close()
-				return "", fmt.Errorf("could only write %d bytes of total %d for pkg %s", n, resp.ContentLength, packageFile.Name())
-			}
-			resp.Body.Close()
-			packageFile.Close()
+		if !satisfiesConstraint(info.Version, pkg.Version) {
+			return "", fmt.Errorf("%s: downloaded version %s does not satisfy constraint %q", pkg.Name, info.Version, pkg.Version)
 		}
 	}
 
-	// download all repo packages in one invocation
-	aptArgs := append(a.options, "-y", "--force-yes", "-d", "install", "--reinstall")
-	args := append(aptArgs, repoPackages...)
-	output, err := a.command.Output("/", "apt-get", args...)
-	if err != nil {
-		return output, err
-	}
-	fmt.Printf("%s\n", output)
-
-	return "", nil
-}
-
-func (a *Apt) Install() (string, error) {
-	files, err := filepath.Glob(filepath.Join(a.cacheDir, "archives", "*.deb"))
-	if err != nil {
-		return "", err
+	// download all essential repo packages in one invocation; failure here
+	// fails the build
+	if len(essentialPackages) > 0 {
+		aptArgs := append(a.options, "-y", "--force-yes", "-d", "install", "--reinstall")
+		args := append(aptArgs, essentialPackages...)
+		output, err := a.command.Output("/", "apt-get", args...)
+		if err != nil {
+			return output, err
+		}
+		fmt.Printf("%s\n", output)
 	}
 
-	for _, file := range files {
-		fmt.Printf("installing " + filepath.Base(file) + "\n")
-		output, err := a.command.Output("/", "dpkg", "-x", file, a.installDir)
+	// download optional repo packages, tolerating ones apt can't resolve
+	output := ""
+	if len(optionalPackages) > 0 {
+		aptArgs := append(a.options, "-y", "--force-yes", "--ignore-missing", "-d", "install", "--reinstall")
+		args := append(aptArgs, optionalPackages...)
+		out, err := a.command.Output("/", "apt-get", args...)
 		if err != nil {
-			fmt.Printf("Error installing packages!\n" + output)
-			return output, err
+			fmt.Printf("warning: could not download all optional packages: %v\n%s\n", err, out)
 		}
+		output = out
 	}
+	fmt.Printf("%s\n", output)
+
 	return "", nil
 }
@@ -0,0 +1,73 @@
+package apt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// PrimaryArch returns the architecture apt should target by default: the
+// first entry of `architectures:` in apt.yml, or the host's dpkg
+// architecture when unset, so existing single-arch apt.yml files keep
+// behaving exactly as before.
+func (a *Apt) PrimaryArch() string {
+	if len(a.Architectures) > 0 {
+		return a.Architectures[0]
+	}
+	return hostDpkgArch()
+}
+
+// hostDpkgArch maps the Go runtime's architecture onto dpkg's naming for
+// arm64 and 386; every other architecture is reported as amd64.
+func hostDpkgArch() string {
+	switch runtime.GOARCH {
+	case "arm64":
+		return "arm64"
+	case "386":
+		return "i386"
+	default:
+		return "amd64"
+	}
+}
+
+// addArchitectures injects APT::Architecture/APT::Architectures:: options
+// for every configured architecture and writes the equivalent of `dpkg
+// --add-architecture` into cacheDir's apt.conf.d, pointing apt-get at
+// that directory with `Dir::Etc::parts` (the sandboxed sourcelist/
+// trusted/preferences paths set up elsewhere already establish the same
+// pattern of overriding a Dir::Etc::* default rather than relying on
+// /etc/apt), so Update/Download resolve packages for every architecture
+// in a.Architectures, not just the host's. When `architectures:` is unset,
+// the primary (host) arch is the only architecture configured this way.
+func (a *Apt) addArchitectures() error {
+	primary := a.PrimaryArch()
+	a.options = append(a.options, "-o", "APT::Architecture="+primary)
+
+	archs := a.Architectures
+	if len(archs) == 0 {
+		archs = []string{primary}
+	}
+
+	for _, arch := range archs {
+		a.options = append(a.options, "-o", "APT::Architectures::="+arch)
+	}
+
+	confDir := filepath.Join(filepath.Dir(a.trustedKeys), "apt.conf.d")
+	if err := os.MkdirAll(confDir, 0755); err != nil {
+		return err
+	}
+
+	conf := "APT::Architectures {\n"
+	for _, arch := range archs {
+		conf += "  \"" + arch + "\";\n"
+	}
+	conf += "};\n"
+
+	if err := ioutil.WriteFile(filepath.Join(confDir, "90apt-buildpack-architectures"), []byte(conf), 0644); err != nil {
+		return err
+	}
+
+	a.options = append(a.options, "-o", "Dir::Etc::parts="+confDir)
+	return nil
+}
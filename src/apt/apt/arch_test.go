@@ -0,0 +1,81 @@
+package apt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrimaryArch(t *testing.T) {
+	a := &Apt{}
+	if got := a.PrimaryArch(); got != hostDpkgArch() {
+		t.Errorf("PrimaryArch() with no architectures configured = %q, want host arch %q", got, hostDpkgArch())
+	}
+
+	a.Architectures = []string{"arm64", "amd64"}
+	if got := a.PrimaryArch(); got != "arm64" {
+		t.Errorf("PrimaryArch() = %q, want first configured architecture %q", got, "arm64")
+	}
+}
+
+func TestSplitArchSuffix(t *testing.T) {
+	cases := []struct {
+		in       string
+		wantName string
+		wantArch string
+	}{
+		{"libfoo:arm64", "libfoo", "arm64"},
+		{"libfoo", "libfoo", ""},
+		{"https://example.com/pkg_1.0_amd64.deb", "https://example.com/pkg_1.0_amd64.deb", ""},
+	}
+
+	for _, c := range cases {
+		name, arch := splitArchSuffix(c.in)
+		if name != c.wantName || arch != c.wantArch {
+			t.Errorf("splitArchSuffix(%q) = (%q, %q), want (%q, %q)", c.in, name, arch, c.wantName, c.wantArch)
+		}
+	}
+}
+
+func TestAddArchitecturesSetsOptionsAndWritesConfDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apt-arch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := &Apt{
+		trustedKeys:   filepath.Join(dir, "etc", "trusted.gpg"),
+		Architectures: []string{"amd64", "arm64"},
+	}
+
+	if err := a.addArchitectures(); err != nil {
+		t.Fatal(err)
+	}
+
+	joined := strings.Join(a.options, " ")
+	for _, want := range []string{
+		"APT::Architecture=amd64",
+		"APT::Architectures::=amd64",
+		"APT::Architectures::=arm64",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("expected options to contain %q, got %q", want, joined)
+		}
+	}
+
+	confDir := filepath.Join(dir, "etc", "apt.conf.d")
+	if !strings.Contains(joined, "Dir::Etc::parts="+confDir) {
+		t.Errorf("expected options to point apt-get at the written apt.conf.d via Dir::Etc::parts, got %q", joined)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(confDir, "90apt-buildpack-architectures"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"amd64"`) || !strings.Contains(string(data), `"arm64"`) {
+		t.Errorf("written apt.conf.d snippet missing expected architectures: %s", data)
+	}
+}
@@ -0,0 +1,253 @@
+package apt
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// downloadJob is a single .deb to fetch into Dest and verify against
+// SHA256 once complete.
+type downloadJob struct {
+	URL    string
+	Dest   string
+	SHA256 string
+}
+
+// downloader fetches .deb files across a worker pool, retrying transient
+// failures with backoff, resuming partial transfers, and caching on
+// ETag/Last-Modified so an unchanged file isn't re-fetched.
+type downloader struct {
+	Workers    int
+	MaxRetries int
+	Progress   io.Writer
+	client     *http.Client
+}
+
+func newDownloader() *downloader {
+	return &downloader{
+		Workers:    runtime.NumCPU(),
+		MaxRetries: 5,
+		Progress:   ioutil.Discard,
+		client:     &http.Client{},
+	}
+}
+
+// downloadAll runs every job across d.Workers goroutines, waits for all of
+// them to finish, and returns the first error any job reported; a failing
+// job does not stop the remaining jobs from running.
+func (d *downloader) downloadAll(jobs []downloadJob) error {
+	workers := d.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobCh := make(chan downloadJob)
+	errCh := make(chan error, len(jobs))
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				errCh <- d.fetch(job)
+			}
+		}()
+	}
+
+	go func() {
+		for _, job := range jobs {
+			jobCh <- job
+		}
+		close(jobCh)
+	}()
+
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetch downloads job.URL into job.Dest. If job.Dest already exists and a
+// conditional request confirms it is unchanged, the existing file is
+// reverified and reused. Otherwise it resumes (or starts) a `.partial`
+// file, retrying 5xx responses and network errors with exponential
+// backoff, and verifies the SHA256 of the completed file before renaming
+// it into place; a completed file that fails verification is removed.
+func (d *downloader) fetch(job downloadJob) error {
+	exists, err := libbuildpack.FileExists(job.Dest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		unchanged, err := d.isUnchanged(job)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			return verifySHA256(job.Dest, job.SHA256)
+		}
+	}
+
+	partialPath := job.Dest + ".partial"
+
+	var lastErr error
+	backoff := time.Second
+	for attempt := 0; attempt <= d.MaxRetries; attempt++ {
+		if attempt > 0 {
+			fmt.Fprintf(d.Progress, "retrying %s (attempt %d/%d) after %v\n", job.URL, attempt+1, d.MaxRetries+1, lastErr)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		etag, retryable, err := d.attempt(job.URL, partialPath)
+		if err == nil {
+			if err := verifySHA256(partialPath, job.SHA256); err != nil {
+				os.Remove(partialPath)
+				return err
+			}
+			if err := os.Rename(partialPath, job.Dest); err != nil {
+				return err
+			}
+			return d.cacheValidators(job, etag)
+		}
+		if !retryable {
+			return err
+		}
+		lastErr = err
+	}
+
+	return fmt.Errorf("giving up on %s after %d attempts: %v", job.URL, d.MaxRetries+1, lastErr)
+}
+
+// attempt makes one GET for url, resuming from the end of partialPath
+// with a Range request when a HEAD request for url shows the server
+// advertises Accept-Ranges: bytes. It returns the response ETag (if any) and
+// whether a failure is worth retrying.
+func (d *downloader) attempt(url, partialPath string) (string, bool, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", false, err
+	}
+
+	resuming := false
+	if fi, err := os.Stat(partialPath); err == nil && fi.Size() > 0 {
+		if head, err := d.client.Head(url); err == nil && head.Header.Get("Accept-Ranges") == "bytes" {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", fi.Size()))
+			resuming = true
+		}
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", true, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && resuming:
+		// server honored our Range request; keep appending
+	case resp.StatusCode == http.StatusOK:
+		resuming = false // server ignored the Range request; start over
+	case resp.StatusCode >= 500:
+		return "", true, fmt.Errorf("%s: %s", url, resp.Status)
+	default:
+		return "", false, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if resuming {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(partialPath, flags, 0644)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	written, err := io.Copy(f, io.TeeReader(resp.Body, progressWriter{d.Progress, url}))
+	if err != nil {
+		return "", true, err
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return "", true, fmt.Errorf("%s: wrote %d of %d bytes", url, written, resp.ContentLength)
+	}
+
+	return resp.Header.Get("ETag"), false, nil
+}
+
+// isUnchanged reports whether job.Dest is already current, preferring a
+// conditional If-None-Match request against a cached ETag and falling
+// back to the Last-Modified comparison used before ETags were tracked.
+func (d *downloader) isUnchanged(job downloadJob) (bool, error) {
+	etagPath := job.Dest + ".etag"
+	if etag, err := ioutil.ReadFile(etagPath); err == nil {
+		req, err := http.NewRequest("GET", job.URL, nil)
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set("If-None-Match", string(etag))
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusNotModified, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	fi, err := os.Stat(job.Dest)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := d.client.Head(job.URL)
+	if err != nil {
+		return false, err
+	}
+	lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return false, nil
+	}
+
+	return !lastModified.After(fi.ModTime()), nil
+}
+
+// cacheValidators persists etag (if any) next to job.Dest so the next run
+// can make a conditional request instead of a full re-download.
+func (d *downloader) cacheValidators(job downloadJob, etag string) error {
+	if etag == "" {
+		return nil
+	}
+	return ioutil.WriteFile(job.Dest+".etag", []byte(etag), 0644)
+}
+
+// progressWriter reports bytes written for a single download to an
+// injectable io.Writer, so callers (and tests) can observe progress
+// without depending on the network.
+type progressWriter struct {
+	w   io.Writer
+	url string
+}
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	fmt.Fprintf(p.w, "%s: +%d bytes\n", p.url, len(b))
+	return len(b), nil
+}
@@ -0,0 +1,101 @@
+package apt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProgressWriterFormatsBytesWritten(t *testing.T) {
+	var buf bytes.Buffer
+	pw := progressWriter{w: &buf, url: "http://example.com/pkg.deb"}
+
+	n, err := pw.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("Write returned n=%d, want 5", n)
+	}
+	if got := buf.String(); got != "http://example.com/pkg.deb: +5 bytes\n" {
+		t.Errorf("progress line = %q", got)
+	}
+}
+
+func TestDownloadAllFetchesAndVerifiesChecksum(t *testing.T) {
+	body := []byte("pretend deb contents")
+	sum := sha256.Sum256(body)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "apt-download-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var progress bytes.Buffer
+	d := newDownloader()
+	d.Progress = &progress
+
+	dest := filepath.Join(dir, "pkg.deb")
+	err = d.downloadAll([]downloadJob{{URL: srv.URL, Dest: dest, SHA256: hex.EncodeToString(sum[:])}})
+	if err != nil {
+		t.Fatalf("downloadAll: %v", err)
+	}
+
+	got, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("downloaded content = %q, want %q", got, body)
+	}
+	if !strings.Contains(progress.String(), srv.URL) {
+		t.Errorf("expected the Progress writer to observe progress for %s, got %q", srv.URL, progress.String())
+	}
+}
+
+func TestDownloadAllFailsOnChecksumMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("actual contents"))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "apt-download-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := newDownloader()
+	d.MaxRetries = 0
+
+	wantSum := sha256.Sum256([]byte("not what the server sent"))
+	dest := filepath.Join(dir, "pkg.deb")
+	err = d.downloadAll([]downloadJob{{URL: srv.URL, Dest: dest, SHA256: hex.EncodeToString(wantSum[:])}})
+	if err == nil {
+		t.Fatal("expected a checksum mismatch error, got nil")
+	}
+}
+
+func TestSetDownloadProgressReachesTheDownloaderUsedByDownload(t *testing.T) {
+	a := &Apt{}
+
+	var buf bytes.Buffer
+	a.SetDownloadProgress(&buf)
+
+	if a.downloader().Progress != &buf {
+		t.Error("SetDownloadProgress did not reach the instance Download will use")
+	}
+}
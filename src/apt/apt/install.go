@@ -0,0 +1,431 @@
+package apt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// InstallStep is a single package extraction in the order returned by Plan.
+type InstallStep struct {
+	File    string
+	Package string
+	Version string
+	Arch    string
+}
+
+type controlInfo struct {
+	Package          string
+	Version          string
+	Architecture     string
+	PreDepends       string
+	Depends          string
+	Provides         []string
+	Replaces         []string
+	Conflicts        []string
+	ConflictVersions map[string]versionedConstraint
+}
+
+// versionedConstraint is the "(<< 2.0)"-style version constraint Debian
+// allows on a single Conflicts/Replaces/Depends entry.
+type versionedConstraint struct {
+	op      string
+	version string
+}
+
+type installNode struct {
+	file       string
+	control    controlInfo
+	preDepends []*installNode
+	depends    []*installNode
+	replaces   []*installNode
+}
+
+var depNameRE = regexp.MustCompile(`^[a-zA-Z0-9.+-]+`)
+
+// depEntryRE matches one Depends/Conflicts/Replaces entry with its
+// optional parenthesized version constraint, e.g. "libfoo (<< 2.0)".
+var depEntryRE = regexp.MustCompile(`^([a-zA-Z0-9.+-]+)\s*(?:\(\s*(<<|<=|=|>=|>>)\s*([^)]+)\)\s*)?$`)
+
+// Plan reads the control file of every *.deb in the archives cache and
+// returns a stable extraction order. Pre-Depends edges are honored before
+// Depends edges, virtual packages are resolved through Provides, and a
+// package extracts after anything it Replaces, so its files win the
+// overwrite. Conflicts is checked once the graph is built: two packages
+// in the same extraction set that conflict without one Replacing the
+// other is an error, since dpkg would refuse to unpack both. Cycles in
+// the remaining (Pre-Depends/Depends/Replaces) graph are legal in
+// Debian; we break them by extracting whichever remaining package has
+// the fewest remaining inbound Pre-Depends edges, logging the choice.
+func (a *Apt) Plan() ([]InstallStep, error) {
+	files, err := filepath.Glob(filepath.Join(a.cacheDir, "archives", "*.deb"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	nodes := make(map[string]*installNode, len(files))
+	provides := make(map[string][]*installNode)
+
+	for _, file := range files {
+		info, err := a.readControl(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read control file for %s: %v", file, err)
+		}
+		node := &installNode{file: file, control: info}
+		nodes[file] = node
+		provides[info.Package] = append(provides[info.Package], node)
+		for _, v := range info.Provides {
+			provides[v] = append(provides[v], node)
+		}
+	}
+
+	for _, file := range files {
+		node := nodes[file]
+		node.preDepends = resolveDeps(node.control.PreDepends, provides, node)
+		node.depends = resolveDeps(node.control.Depends, provides, node)
+		node.replaces = resolveNames(node.control.Replaces, provides, node)
+	}
+
+	if err := checkConflicts(files, nodes, provides); err != nil {
+		return nil, err
+	}
+
+	return topoSort(files, nodes), nil
+}
+
+// Install extracts every downloaded .deb into installDir in the order
+// computed by Plan, so that a package's Pre-Depends and Depends are
+// always unpacked ahead of it. Each package lands under an arch-specific
+// subdirectory (installDir/<arch>/) so a downstream buildpack can pick
+// the right one at staging time; arch-independent (`all`) packages go
+// under the primary architecture.
+func (a *Apt) Install() (string, error) {
+	steps, err := a.Plan()
+	if err != nil {
+		return "", err
+	}
+
+	for _, step := range steps {
+		arch := step.Arch
+		if arch == "" || arch == "all" {
+			arch = a.PrimaryArch()
+		}
+		dest := filepath.Join(a.installDir, arch)
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return "", err
+		}
+
+		fmt.Printf("installing " + filepath.Base(step.File) + "\n")
+		output, err := a.command.Output("/", "dpkg", "-x", step.File, dest)
+		if err != nil {
+			fmt.Printf("Error installing packages!\n" + output)
+			return output, err
+		}
+	}
+
+	if err := a.writeManifest(steps); err != nil {
+		return "", err
+	}
+
+	return "", nil
+}
+
+func (a *Apt) readControl(file string) (controlInfo, error) {
+	out, err := a.command.Output("/", "dpkg-deb", "-f", file)
+	if err != nil {
+		return controlInfo{}, err
+	}
+	return parseControlInfo(out), nil
+}
+
+// parseControlInfo parses the stanza printed by `dpkg-deb -f`, folding
+// continuation lines of Depends/Pre-Depends back onto the field they
+// belong to.
+func parseControlInfo(raw string) controlInfo {
+	var info controlInfo
+	var field string
+
+	for _, line := range strings.Split(raw, "\n") {
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			switch field {
+			case "Depends":
+				info.Depends += " " + strings.TrimSpace(line)
+			case "Pre-Depends":
+				info.PreDepends += " " + strings.TrimSpace(line)
+			}
+			continue
+		}
+
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+		key := strings.TrimSpace(line[:idx])
+		value := strings.TrimSpace(line[idx+1:])
+		field = key
+
+		switch key {
+		case "Package":
+			info.Package = value
+		case "Version":
+			info.Version = value
+		case "Architecture":
+			info.Architecture = value
+		case "Pre-Depends":
+			info.PreDepends = value
+		case "Depends":
+			info.Depends = value
+		case "Provides":
+			info.Provides = splitPackageNames(value)
+		case "Replaces":
+			info.Replaces = splitPackageNames(value)
+		case "Conflicts":
+			info.Conflicts = splitPackageNames(value)
+			info.ConflictVersions = parseVersionedConstraints(value)
+		}
+	}
+
+	return info
+}
+
+// splitPackageNames splits a comma-separated control field (Provides,
+// Replaces, Conflicts) into bare package names, dropping version
+// constraints.
+func splitPackageNames(value string) []string {
+	var names []string
+	for _, entry := range strings.Split(value, ",") {
+		if name := depNameRE.FindString(strings.TrimSpace(entry)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseVersionedConstraints maps every entry of a comma-separated control
+// field that carries a parenthesized version constraint (e.g. "libfoo
+// (<< 2.0)") to that constraint, by bare package name. Entries with no
+// constraint are omitted.
+func parseVersionedConstraints(value string) map[string]versionedConstraint {
+	constraints := make(map[string]versionedConstraint)
+	for _, entry := range strings.Split(value, ",") {
+		m := depEntryRE.FindStringSubmatch(strings.TrimSpace(entry))
+		if m == nil || m[2] == "" {
+			continue
+		}
+		constraints[m[1]] = versionedConstraint{op: m[2], version: strings.TrimSpace(m[3])}
+	}
+	return constraints
+}
+
+// parseDepGroups splits a Depends/Pre-Depends field into its comma
+// separated groups, each containing the bare package names of its `|`
+// alternatives.
+func parseDepGroups(field string) [][]string {
+	if strings.TrimSpace(field) == "" {
+		return nil
+	}
+
+	var groups [][]string
+	for _, entry := range strings.Split(field, ",") {
+		var alts []string
+		for _, alt := range strings.Split(entry, "|") {
+			if name := depNameRE.FindString(strings.TrimSpace(alt)); name != "" {
+				alts = append(alts, name)
+			}
+		}
+		if len(alts) > 0 {
+			groups = append(groups, alts)
+		}
+	}
+	return groups
+}
+
+// resolveDeps maps a Depends/Pre-Depends field onto the local install
+// nodes that satisfy it, resolving virtual packages through provides.
+// Alternatives (`a | b`) resolve to every local alternative present,
+// since any one of them extracting first is sufficient in practice.
+func resolveDeps(field string, provides map[string][]*installNode, self *installNode) []*installNode {
+	var deps []*installNode
+	seen := make(map[*installNode]bool)
+
+	for _, alts := range parseDepGroups(field) {
+		for _, name := range alts {
+			for _, n := range provides[name] {
+				if n == self || seen[n] {
+					continue
+				}
+				seen[n] = true
+				deps = append(deps, n)
+			}
+		}
+	}
+
+	return deps
+}
+
+// resolveNames maps an already-parsed list of bare package names (as
+// splitPackageNames produces for Provides/Replaces/Conflicts) onto the
+// local install nodes that provide them.
+func resolveNames(names []string, provides map[string][]*installNode, self *installNode) []*installNode {
+	var nodes []*installNode
+	seen := make(map[*installNode]bool)
+
+	for _, name := range names {
+		for _, n := range provides[name] {
+			if n == self || seen[n] {
+				continue
+			}
+			seen[n] = true
+			nodes = append(nodes, n)
+		}
+	}
+
+	return nodes
+}
+
+// checkConflicts reports an error if two packages in the same extraction
+// set declare a Conflicts relationship that isn't resolved by a matching
+// Replaces in either direction (the standard idiom for a renamed or
+// split package) or by a version constraint on the Conflicts entry that
+// the other package's own version doesn't fall into. dpkg itself would
+// refuse to unpack both, so we fail the build rather than silently
+// extracting a broken pair.
+func checkConflicts(files []string, nodes map[string]*installNode, provides map[string][]*installNode) error {
+	for _, file := range files {
+		node := nodes[file]
+		for _, name := range node.control.Conflicts {
+			for _, other := range provides[name] {
+				if other == node {
+					continue
+				}
+				if containsNode(node.replaces, other) || containsNode(other.replaces, node) {
+					continue
+				}
+				if vc, ok := node.control.ConflictVersions[name]; ok && !conflictVersionApplies(vc, other.control.Version) {
+					continue
+				}
+				return fmt.Errorf("%s and %s conflict and neither replaces the other", node.control.Package, other.control.Package)
+			}
+		}
+	}
+	return nil
+}
+
+// conflictVersionApplies reports whether other's version falls into the
+// range named by a Conflicts entry's version constraint, translating
+// Debian's doubled strict operators ("<<", ">>") into the ones
+// satisfiesConstraint understands.
+func conflictVersionApplies(vc versionedConstraint, otherVersion string) bool {
+	op := vc.op
+	switch op {
+	case "<<":
+		op = "<"
+	case ">>":
+		op = ">"
+	}
+	return satisfiesConstraint(otherVersion, op+vc.version)
+}
+
+func containsNode(nodes []*installNode, target *installNode) bool {
+	for _, n := range nodes {
+		if n == target {
+			return true
+		}
+	}
+	return false
+}
+
+// topoSort runs Kahn's algorithm over the combined Pre-Depends/Depends/
+// Replaces graph. Ties among ready nodes are broken by filename for a
+// stable, reproducible plan. When a cycle leaves no node ready, it
+// extracts the remaining node with the fewest unresolved inbound
+// Pre-Depends edges.
+func topoSort(files []string, nodes map[string]*installNode) []InstallStep {
+	nodeList := make([]*installNode, 0, len(files))
+	for _, file := range files {
+		nodeList = append(nodeList, nodes[file])
+	}
+
+	inDegree := make(map[*installNode]int, len(nodeList))
+	preInDegree := make(map[*installNode]int, len(nodeList))
+	dependents := make(map[*installNode][]*installNode)
+	preDependents := make(map[*installNode][]*installNode)
+
+	for _, n := range nodeList {
+		for _, dep := range n.preDepends {
+			inDegree[n]++
+			preInDegree[n]++
+			dependents[dep] = append(dependents[dep], n)
+			preDependents[dep] = append(preDependents[dep], n)
+		}
+		for _, dep := range n.depends {
+			inDegree[n]++
+			dependents[dep] = append(dependents[dep], n)
+		}
+		for _, dep := range n.replaces {
+			inDegree[n]++
+			dependents[dep] = append(dependents[dep], n)
+		}
+	}
+
+	remaining := make(map[*installNode]bool, len(nodeList))
+	for _, n := range nodeList {
+		remaining[n] = true
+	}
+
+	order := make([]InstallStep, 0, len(nodeList))
+
+	for len(remaining) > 0 {
+		var ready []*installNode
+		for _, n := range nodeList {
+			if remaining[n] && inDegree[n] == 0 {
+				ready = append(ready, n)
+			}
+		}
+
+		var next *installNode
+		if len(ready) > 0 {
+			sort.Slice(ready, func(i, j int) bool { return ready[i].file < ready[j].file })
+			next = ready[0]
+		} else {
+			var cycle []*installNode
+			for _, n := range nodeList {
+				if remaining[n] {
+					cycle = append(cycle, n)
+				}
+			}
+			sort.Slice(cycle, func(i, j int) bool {
+				if preInDegree[cycle[i]] != preInDegree[cycle[j]] {
+					return preInDegree[cycle[i]] < preInDegree[cycle[j]]
+				}
+				return cycle[i].file < cycle[j].file
+			})
+			next = cycle[0]
+			fmt.Printf("dependency cycle detected; extracting %s out of order to break it\n", filepath.Base(next.file))
+		}
+
+		order = append(order, InstallStep{File: next.file, Package: next.control.Package, Version: next.control.Version, Arch: next.control.Architecture})
+		delete(remaining, next)
+
+		for _, dependent := range dependents[next] {
+			if remaining[dependent] {
+				inDegree[dependent]--
+			}
+		}
+		for _, dependent := range preDependents[next] {
+			if remaining[dependent] {
+				preInDegree[dependent]--
+			}
+		}
+	}
+
+	return order
+}
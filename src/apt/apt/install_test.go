@@ -0,0 +1,166 @@
+package apt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeInstallCommand stubs `dpkg-deb -f <file>` with canned control
+// stanzas keyed by the full path Plan globs for, so Plan can be exercised
+// without a real dpkg-deb or real .deb archives.
+type fakeInstallCommand struct {
+	control map[string]string
+}
+
+func (f *fakeInstallCommand) Output(dir, cmd string, args ...string) (string, error) {
+	if cmd != "dpkg-deb" {
+		return "", fmt.Errorf("unexpected command %s %v", cmd, args)
+	}
+	file := args[len(args)-1]
+	out, ok := f.control[file]
+	if !ok {
+		return "", fmt.Errorf("no control info stubbed for %s", file)
+	}
+	return out, nil
+}
+
+// planFixture creates an empty .deb for every name in control (so
+// filepath.Glob finds it) and returns an *Apt whose dpkg-deb calls are
+// answered from control.
+func planFixture(t *testing.T, control map[string]string) *Apt {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "apt-install-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	archives := filepath.Join(dir, "archives")
+	if err := os.MkdirAll(archives, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	stubbed := make(map[string]string, len(control))
+	for name, stanza := range control {
+		path := filepath.Join(archives, name)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		stubbed[path] = stanza
+	}
+
+	return &Apt{
+		command:  &fakeInstallCommand{control: stubbed},
+		cacheDir: dir,
+	}
+}
+
+func packageNames(steps []InstallStep) []string {
+	names := make([]string, len(steps))
+	for i, s := range steps {
+		names[i] = s.Package
+	}
+	return names
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestPlanOrdersPreDependsBeforeDependents(t *testing.T) {
+	a := planFixture(t, map[string]string{
+		"app.deb":  "Package: app\nVersion: 1.0\nPre-Depends: base\n",
+		"base.deb": "Package: base\nVersion: 1.0\n",
+	})
+
+	steps, err := a.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := packageNames(steps)
+	if indexOf(names, "base") > indexOf(names, "app") {
+		t.Errorf("expected base before app, got order %v", names)
+	}
+}
+
+func TestPlanOrdersReplacesAfterReplaced(t *testing.T) {
+	a := planFixture(t, map[string]string{
+		"new.deb": "Package: new\nVersion: 2.0\nReplaces: old\n",
+		"old.deb": "Package: old\nVersion: 1.0\n",
+	})
+
+	steps, err := a.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := packageNames(steps)
+	if indexOf(names, "old") > indexOf(names, "new") {
+		t.Errorf("expected old before new (the replacing package), got order %v", names)
+	}
+}
+
+func TestPlanRejectsUnresolvedConflicts(t *testing.T) {
+	a := planFixture(t, map[string]string{
+		"a.deb": "Package: a\nVersion: 1.0\nConflicts: b\n",
+		"b.deb": "Package: b\nVersion: 1.0\nConflicts: a\n",
+	})
+
+	_, err := a.Plan()
+	if err == nil {
+		t.Fatal("expected an error for two conflicting packages with no Replaces, got nil")
+	}
+	if !strings.Contains(err.Error(), "conflict") {
+		t.Errorf("expected error to mention the conflict, got: %v", err)
+	}
+}
+
+func TestPlanAllowsConflictsResolvedByReplaces(t *testing.T) {
+	a := planFixture(t, map[string]string{
+		"new.deb": "Package: new\nVersion: 2.0\nReplaces: old\nConflicts: old\n",
+		"old.deb": "Package: old\nVersion: 1.0\n",
+	})
+
+	steps, err := a.Plan()
+	if err != nil {
+		t.Fatalf("expected Replaces to excuse the Conflicts, got error: %v", err)
+	}
+	names := packageNames(steps)
+	if indexOf(names, "old") > indexOf(names, "new") {
+		t.Errorf("expected old before new, got order %v", names)
+	}
+}
+
+func TestPlanAllowsConflictsNotInVersionRange(t *testing.T) {
+	a := planFixture(t, map[string]string{
+		"a.deb": "Package: a\nVersion: 1.0\nConflicts: libfoo (<< 2.0)\n",
+		"b.deb": "Package: libfoo\nVersion: 2.5\n",
+	})
+
+	if _, err := a.Plan(); err != nil {
+		t.Fatalf("expected no conflict since libfoo 2.5 is outside the (<< 2.0) range, got: %v", err)
+	}
+}
+
+func TestPlanRejectsConflictsInVersionRange(t *testing.T) {
+	a := planFixture(t, map[string]string{
+		"a.deb": "Package: a\nVersion: 1.0\nConflicts: libfoo (<< 2.0)\n",
+		"b.deb": "Package: libfoo\nVersion: 1.5\n",
+	})
+
+	_, err := a.Plan()
+	if err == nil {
+		t.Fatal("expected a conflict error since libfoo 1.5 falls inside the (<< 2.0) range")
+	}
+}
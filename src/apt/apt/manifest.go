@@ -0,0 +1,59 @@
+package apt
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// ManifestEntry records exactly what was staged for one package, so
+// downstream buildpacks and audit tooling can consume the resolved
+// package set without re-deriving it from apt.yml.
+type ManifestEntry struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	SHA256  string `json:"sha256"`
+	Repo    string `json:"source_repo,omitempty"`
+}
+
+// writeManifest emits manifest.json next to installDir listing the
+// (name, version, sha256, source-repo) tuple of every package Install
+// just extracted.
+func (a *Apt) writeManifest(steps []InstallStep) error {
+	entries := make([]ManifestEntry, 0, len(steps))
+	for _, step := range steps {
+		sha256, repo, ok := a.lookupPackageSource(step.Package)
+		if !ok {
+			sha256, ok = a.lookupPkgSHA256(filepath.Base(step.File))
+		}
+		if !ok {
+			sha256, _ = a.lookupPkgSHA256(step.Package)
+		}
+		entries = append(entries, ManifestEntry{
+			Name:    step.Package,
+			Version: step.Version,
+			SHA256:  sha256,
+			Repo:    repo,
+		})
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(filepath.Dir(a.installDir), "manifest.json"), data, 0644)
+}
+
+// lookupPkgSHA256 falls back to the explicit `sha256:` field on a
+// configured Package when a step's package wasn't covered by a verified
+// repo index, matching name against the package name or against the
+// basename of a direct .deb URL.
+func (a *Apt) lookupPkgSHA256(name string) (string, bool) {
+	for _, pkg := range a.Packages {
+		if filepath.Base(pkg.Name) == name || pkg.Name == name {
+			return pkg.SHA256, pkg.SHA256 != ""
+		}
+	}
+	return "", false
+}
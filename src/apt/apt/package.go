@@ -0,0 +1,62 @@
+package apt
+
+import "strings"
+
+// Package describes a single entry under `packages` in apt.yml. It may be
+// written as a bare string ("curl" or a direct .deb URL) or as a map
+// carrying additional verification metadata.
+type Package struct {
+	Name      string `yaml:"name"`
+	SHA256    string `yaml:"sha256"`
+	Arch      string `yaml:"arch"`
+	Version   string `yaml:"version"`
+	Hold      bool   `yaml:"hold"`
+	Essential bool   `yaml:"essential"`
+}
+
+func (p *Package) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		p.Name, p.Arch = splitArchSuffix(name)
+		return nil
+	}
+
+	data := struct {
+		Name      string `yaml:"name"`
+		SHA256    string `yaml:"sha256"`
+		Arch      string `yaml:"arch"`
+		Version   string `yaml:"version"`
+		Hold      bool   `yaml:"hold"`
+		Essential bool   `yaml:"essential"`
+	}{}
+	if err := unmarshal(&data); err != nil {
+		return err
+	}
+
+	p.Name = data.Name
+	p.SHA256 = data.SHA256
+	p.Arch = data.Arch
+	p.Version = data.Version
+	p.Hold = data.Hold
+	p.Essential = data.Essential
+	return nil
+}
+
+// IsDebURL reports whether p names a directly downloadable .deb file rather
+// than a package to be resolved against the configured repos.
+func (p *Package) IsDebURL() bool {
+	return strings.HasSuffix(p.Name, ".deb")
+}
+
+// splitArchSuffix splits apt's `name:arch` package syntax (e.g.
+// "libfoo:arm64") into its name and architecture. URLs are left alone,
+// since "://" would otherwise be mistaken for the separator.
+func splitArchSuffix(name string) (string, string) {
+	if strings.Contains(name, "://") {
+		return name, ""
+	}
+	if idx := strings.LastIndex(name, ":"); idx != -1 {
+		return name[:idx], name[idx+1:]
+	}
+	return name, ""
+}
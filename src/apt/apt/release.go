@@ -0,0 +1,240 @@
+package apt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// packageIndex maps a repo's signed Packages stanzas to their SHA256, both
+// by package name (`Package:`, for packages apt-get itself resolves) and
+// by the pool filename (`Filename:`, for matching a directly downloaded
+// .deb before its control file has even been read).
+type packageIndex struct {
+	byName     map[string]string
+	byFilename map[string]string
+}
+
+// releaseIndexKey identifies one Packages file within a repo.
+type releaseIndexKey struct {
+	repo      string
+	component string
+	arch      string
+}
+
+// VerifyRepos fetches and GPG-verifies the InRelease (or Release plus
+// Release.gpg) file for every configured repo against a.trustedKeys, and
+// caches the SHA256 of every package each repo's Packages index lists.
+// Only repos written as `deb <url> <dist> <component>...` are verified;
+// other repo lines (deb-src, an options block, a flat repo) are skipped.
+// When `snapshot:` is set, the snapshot.debian.org URL is the one fetched.
+// It must run after AddKeys/AddRepos and before any verified lookup, so
+// Download calls it itself before resolving .deb URL checksums; exported
+// so callers that want the verified indices without downloading anything
+// can still invoke it directly.
+func (a *Apt) VerifyRepos() error {
+	if a.indices == nil {
+		a.indices = make(map[releaseIndexKey]packageIndex)
+	}
+
+	keyring, err := a.loadKeyring()
+	if err != nil {
+		return err
+	}
+
+	for _, repo := range a.Repos {
+		fields := strings.Fields(a.rewriteForSnapshot(repo.Name))
+		if len(fields) < 4 || fields[0] != "deb" {
+			continue
+		}
+		baseURL, dist, components := fields[1], fields[2], fields[3:]
+
+		release, err := a.fetchVerifiedRelease(baseURL, dist, keyring)
+		if err != nil {
+			return fmt.Errorf("could not verify release for %q: %v", repo.Name, err)
+		}
+
+		if a.Snapshot != "" {
+			a.recordSnapshotHash(repo.Name, release)
+		}
+
+		archs := a.Architectures
+		if len(archs) == 0 {
+			archs = []string{a.PrimaryArch()}
+		}
+
+		for _, component := range components {
+			for _, arch := range archs {
+				path := component + "/binary-" + arch + "/Packages"
+				index, err := a.fetchPackageIndex(baseURL, dist, path, release)
+				if err != nil {
+					continue // not every repo publishes every component/arch
+				}
+				a.indices[releaseIndexKey{repo: repo.Name, component: component, arch: arch}] = index
+			}
+		}
+	}
+
+	if a.Snapshot != "" {
+		if err := a.writeSnapshotLock(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *Apt) loadKeyring() (openpgp.EntityList, error) {
+	f, err := os.Open(a.trustedKeys)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return openpgp.EntityList{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	return openpgp.ReadKeyRing(f)
+}
+
+// fetchVerifiedRelease downloads InRelease (preferred, clearsigned) or
+// falls back to Release + a detached Release.gpg, checks the signature
+// against keyring, and returns the verified plaintext.
+func (a *Apt) fetchVerifiedRelease(baseURL, dist string, keyring openpgp.EntityList) ([]byte, error) {
+	if body, err := a.get(baseURL + "/dists/" + dist + "/InRelease"); err == nil {
+		block, _ := clearsign.Decode(body)
+		if block == nil {
+			return nil, fmt.Errorf("InRelease is not a clearsigned message")
+		}
+		if _, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body); err != nil {
+			return nil, err
+		}
+		return block.Plaintext, nil
+	}
+
+	release, err := a.get(baseURL + "/dists/" + dist + "/Release")
+	if err != nil {
+		return nil, err
+	}
+	sig, err := a.get(baseURL + "/dists/" + dist + "/Release.gpg")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(release), bytes.NewReader(sig)); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+func (a *Apt) fetchPackageIndex(baseURL, dist, path string, release []byte) (packageIndex, error) {
+	if !releaseListsFile(release, path) {
+		return packageIndex{}, fmt.Errorf("%s is not listed in Release", path)
+	}
+
+	if body, err := a.get(baseURL + "/dists/" + dist + "/" + path + ".gz"); err == nil {
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return packageIndex{}, err
+		}
+		defer gz.Close()
+		body, err = ioutil.ReadAll(gz)
+		if err != nil {
+			return packageIndex{}, err
+		}
+		return parsePackageIndex(body), nil
+	}
+
+	body, err := a.get(baseURL + "/dists/" + dist + "/" + path)
+	if err != nil {
+		return packageIndex{}, err
+	}
+	return parsePackageIndex(body), nil
+}
+
+func (a *Apt) get(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// releaseListsFile reports whether the Release file's checksum section
+// names path, which is how we know the index we are about to fetch is
+// actually covered by the signature we just verified.
+func releaseListsFile(release []byte, path string) bool {
+	return bytes.Contains(release, []byte(" "+path))
+}
+
+// parsePackageIndex parses a Debian Packages file, indexing each stanza's
+// SHA256 by both its Package name and its pool Filename's basename.
+func parsePackageIndex(body []byte) packageIndex {
+	index := packageIndex{byName: make(map[string]string), byFilename: make(map[string]string)}
+	var name, filename, sha256 string
+
+	flush := func() {
+		if sha256 == "" {
+			return
+		}
+		if name != "" {
+			index.byName[name] = sha256
+		}
+		if filename != "" {
+			index.byFilename[path.Base(filename)] = sha256
+		}
+	}
+
+	for _, line := range strings.Split(string(body), "\n") {
+		switch {
+		case strings.HasPrefix(line, "Package: "):
+			name = strings.TrimPrefix(line, "Package: ")
+		case strings.HasPrefix(line, "Filename: "):
+			filename = strings.TrimPrefix(line, "Filename: ")
+		case strings.HasPrefix(line, "SHA256: "):
+			sha256 = strings.TrimPrefix(line, "SHA256: ")
+		case line == "":
+			flush()
+			name, filename, sha256 = "", "", ""
+		}
+	}
+	flush()
+
+	return index
+}
+
+// lookupPackageSource returns the verified checksum for a package name and
+// the repo it came from, across every indexed repo/component/arch
+// combination.
+func (a *Apt) lookupPackageSource(name string) (sha256, repo string, ok bool) {
+	for key, index := range a.indices {
+		if sum, ok := index.byName[name]; ok {
+			return sum, key.repo, true
+		}
+	}
+	return "", "", false
+}
+
+// lookupSHA256ByFilename returns the verified checksum for a pool filename
+// (e.g. "curl_7.68.0-1_amd64.deb") across every indexed repo/component/arch
+// combination. Used to check a directly downloaded .deb URL before its own
+// control file has been read.
+func (a *Apt) lookupSHA256ByFilename(filename string) (string, bool) {
+	for _, index := range a.indices {
+		if sum, ok := index.byFilename[filename]; ok {
+			return sum, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,135 @@
+package apt
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+func TestFetchVerifiedReleaseAcceptsValidSignatureAndRejectsTampering(t *testing.T) {
+	entity, err := openpgp.NewEntity("apt-buildpack test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := "Codename: stable\nArchitectures: amd64\n"
+
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, entity.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	signed := buf.Bytes()
+
+	tampered := append([]byte(nil), signed...)
+	idx := bytes.Index(tampered, []byte("Codename: stable"))
+	if idx == -1 {
+		t.Fatal("fixture is missing the expected marker to corrupt")
+	}
+	tampered[idx+len("Codename: ")] = 'X'
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dists/stable/InRelease", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(signed)
+	})
+	mux.HandleFunc("/dists/unstable/InRelease", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(tampered)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	keyring := openpgp.EntityList{entity}
+	a := &Apt{}
+
+	release, err := a.fetchVerifiedRelease(srv.URL, "stable", keyring)
+	if err != nil {
+		t.Fatalf("expected a validly signed InRelease to verify, got: %v", err)
+	}
+	if !strings.Contains(string(release), "Codename: stable") {
+		t.Errorf("verified plaintext missing expected content: %s", release)
+	}
+
+	if _, err := a.fetchVerifiedRelease(srv.URL, "unstable", keyring); err == nil {
+		t.Fatal("expected a tampered InRelease to fail signature verification")
+	}
+}
+
+func TestFetchVerifiedReleaseRejectsUntrustedSigner(t *testing.T) {
+	signer, err := openpgp.NewEntity("signer", "", "signer@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := openpgp.NewEntity("not the signer", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, signer.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "Codename: stable\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	a := &Apt{}
+	if _, err := a.fetchVerifiedRelease(srv.URL, "stable", openpgp.EntityList{other}); err == nil {
+		t.Fatal("expected verification against a keyring that doesn't contain the signer to fail")
+	}
+}
+
+func TestParsePackageIndexAcrossStanzas(t *testing.T) {
+	sumFoo := strings.Repeat("a", 64)
+	sumBar := strings.Repeat("b", 64)
+
+	body := []byte(strings.Join([]string{
+		"Package: foo",
+		"Version: 1.0",
+		"Filename: pool/main/f/foo/foo_1.0_amd64.deb",
+		"SHA256: " + sumFoo,
+		"",
+		"Package: bar",
+		"Version: 2.0",
+		"Filename: pool/main/b/bar/bar_2.0_amd64.deb",
+		"SHA256: " + sumBar,
+	}, "\n"))
+
+	index := parsePackageIndex(body)
+
+	if got := index.byName["foo"]; got != sumFoo {
+		t.Errorf("byName[foo] = %q, want %q", got, sumFoo)
+	}
+	if got := index.byFilename["foo_1.0_amd64.deb"]; got != sumFoo {
+		t.Errorf("byFilename[foo_1.0_amd64.deb] = %q, want %q", got, sumFoo)
+	}
+	if got := index.byName["bar"]; got != sumBar {
+		t.Errorf("byName[bar] = %q, want %q (last stanza has no trailing blank line but must still flush)", got, sumBar)
+	}
+	if got := index.byFilename["bar_2.0_amd64.deb"]; got != sumBar {
+		t.Errorf("byFilename[bar_2.0_amd64.deb] = %q, want %q", got, sumBar)
+	}
+	if len(index.byName) != 2 || len(index.byFilename) != 2 {
+		t.Errorf("expected exactly 2 entries per map, got byName=%d byFilename=%d", len(index.byName), len(index.byFilename))
+	}
+}
@@ -0,0 +1,101 @@
+package apt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// snapshotSourceLineRE matches a `deb`/`deb-src` sources.list line,
+// tolerating an optional bracketed options block (e.g. "deb [arch=amd64]
+// https://..." or "deb [signed-by=/etc/apt/keyrings/x.gpg] https://...")
+// between the type and the URL, since that form is routine for
+// third-party repos.
+var snapshotSourceLineRE = regexp.MustCompile(`^deb(-src)?\s+(\[[^\]]*\]\s+)?(https?://\S+)(\s+.*)$`)
+
+// snapshotLock records what a `snapshot:`-pinned build actually resolved,
+// so a later build against the same lock file can be verified byte-for-
+// byte reproducible.
+type snapshotLock struct {
+	Snapshot      string            `json:"snapshot"`
+	ReleaseSHA256 map[string]string `json:"release_sha256"`
+}
+
+// rewriteForSnapshot rewrites a `deb http(s)://host/path ...` sources.list
+// line (with or without a bracketed options block before the URL) onto
+// snapshot.debian.org, pinned to a.Snapshot. Lines that are already
+// comments, blank, already point at snapshot.debian.org, or that this
+// regex can't parse at all pass through unchanged.
+func (a *Apt) rewriteForSnapshot(line string) string {
+	if a.Snapshot == "" {
+		return line
+	}
+
+	m := snapshotSourceLineRE.FindStringSubmatch(line)
+	if m == nil {
+		return line
+	}
+
+	u, err := url.Parse(m[3])
+	if err != nil || u.Host == "snapshot.debian.org" {
+		return line
+	}
+
+	return fmt.Sprintf("deb%s %shttps://snapshot.debian.org/archive/%s/%s%s%s", m[1], m[2], u.Host, a.Snapshot, u.Path, m[4])
+}
+
+// pinSnapshot rewrites every repo line already copied into a.sourceList
+// onto snapshot.debian.org and pins Acquire::Check-Valid-Until=false,
+// since a snapshot's InRelease is, by design, long past its Valid-Until
+// date. It is a no-op unless apt.yml sets `snapshot:`.
+func (a *Apt) pinSnapshot() error {
+	if a.Snapshot == "" {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(a.sourceList)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(raw), "\n")
+	for i, line := range lines {
+		lines[i] = a.rewriteForSnapshot(line)
+	}
+
+	if err := ioutil.WriteFile(a.sourceList, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		return err
+	}
+
+	a.options = append(a.options, "-o", "Acquire::Check-Valid-Until=false")
+	return nil
+}
+
+// recordSnapshotHash notes the SHA256 of repo's verified InRelease/Release
+// body, for later writing into snapshot.lock.
+func (a *Apt) recordSnapshotHash(repo string, release []byte) {
+	if a.snapshotHashes == nil {
+		a.snapshotHashes = make(map[string]string)
+	}
+	sum := sha256.Sum256(release)
+	a.snapshotHashes[repo] = hex.EncodeToString(sum[:])
+}
+
+// writeSnapshotLock emits snapshot.lock recording the pinned snapshot ID
+// and the SHA256 of every InRelease pulled while verifying repos, so a
+// subsequent build against the same lock file can assert it fetched the
+// exact same bytes.
+func (a *Apt) writeSnapshotLock() error {
+	lock := snapshotLock{Snapshot: a.Snapshot, ReleaseSHA256: a.snapshotHashes}
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(a.baseCacheDir, "snapshot.lock"), data, 0644)
+}
@@ -0,0 +1,51 @@
+package apt
+
+import "testing"
+
+func TestRewriteForSnapshotHandlesBracketedOptions(t *testing.T) {
+	a := &Apt{Snapshot: "20200101T000000Z"}
+
+	cases := []struct {
+		name string
+		line string
+		want string
+	}{
+		{
+			name: "no options",
+			line: "deb https://deb.debian.org/debian stable main",
+			want: "deb https://snapshot.debian.org/archive/deb.debian.org/20200101T000000Z/debian stable main",
+		},
+		{
+			name: "arch option",
+			line: "deb [arch=amd64] https://download.docker.com/linux/debian stable main",
+			want: "deb [arch=amd64] https://snapshot.debian.org/archive/download.docker.com/20200101T000000Z/linux/debian stable main",
+		},
+		{
+			name: "signed-by option",
+			line: "deb-src [signed-by=/etc/apt/keyrings/docker.gpg] https://download.docker.com/linux/debian stable main",
+			want: "deb-src [signed-by=/etc/apt/keyrings/docker.gpg] https://snapshot.debian.org/archive/download.docker.com/20200101T000000Z/linux/debian stable main",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := a.rewriteForSnapshot(c.line); got != c.want {
+				t.Errorf("rewriteForSnapshot(%q) = %q, want %q", c.line, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRewriteForSnapshotLeavesCommentsBlanksAndSnapshotLinesUnchanged(t *testing.T) {
+	a := &Apt{Snapshot: "20200101T000000Z"}
+
+	for _, line := range []string{
+		"# a comment",
+		"",
+		"deb https://snapshot.debian.org/archive/debian/20200101T000000Z/debian stable main",
+	} {
+		if got := a.rewriteForSnapshot(line); got != line {
+			t.Errorf("rewriteForSnapshot(%q) = %q, want unchanged", line, got)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package apt
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var versionConstraintRE = regexp.MustCompile(`^(>=|<=|==|>|<|=)?\s*(.+)$`)
+
+// parseVersionConstraint splits a `version:` field such as ">=1.2.3" into
+// its comparison operator (defaulting to "=" for a bare version) and the
+// version it is compared against.
+func parseVersionConstraint(field string) (op, version string) {
+	m := versionConstraintRE.FindStringSubmatch(strings.TrimSpace(field))
+	if m == nil {
+		return "=", strings.TrimSpace(field)
+	}
+	op = m[1]
+	if op == "" {
+		op = "="
+	}
+	return op, m[2]
+}
+
+// aptGetVersion returns the bare version apt-get's `pkg=version` syntax
+// expects for a version constraint. apt-get has no concept of "at least"
+// on the command line, so we pin exactly to the version named in the
+// constraint; Essential/Hold make sure that pin is enforced rather than
+// silently ignored.
+func aptGetVersion(field string) string {
+	if field == "" {
+		return ""
+	}
+	_, version := parseVersionConstraint(field)
+	return version
+}
+
+// satisfiesConstraint reports whether installedVersion satisfies a
+// `version:` field such as ">=1.2.3" or a bare exact version.
+func satisfiesConstraint(installedVersion, field string) bool {
+	if field == "" {
+		return true
+	}
+	op, want := parseVersionConstraint(field)
+	cmp := compareVersions(installedVersion, want)
+
+	switch op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	default: // "=", "=="
+		return cmp == 0
+	}
+}
+
+// compareVersions compares two Debian-style version strings
+// (epoch:upstream-revision), alternating between non-digit and digit runs
+// the same way dpkg's own comparator does: non-digit runs compare
+// character by character with '~' sorting before everything, including
+// the end of a run, so that "1.0~rc1" < "1.0"; digit runs compare
+// numerically. An epoch, if present, is compared numerically first (a
+// missing epoch counts as 0). It returns -1, 0, or 1, the same convention
+// as strings.Compare.
+func compareVersions(a, b string) int {
+	epochA, epochB := 0, 0
+	if i := strings.Index(a, ":"); i >= 0 {
+		epochA, _ = strconv.Atoi(a[:i])
+		a = a[i+1:]
+	}
+	if i := strings.Index(b, ":"); i >= 0 {
+		epochB, _ = strconv.Atoi(b[:i])
+		b = b[i+1:]
+	}
+	if epochA != epochB {
+		if epochA < epochB {
+			return -1
+		}
+		return 1
+	}
+
+	for len(a) > 0 || len(b) > 0 {
+		var an, bn string
+		an, a = splitNonDigits(a)
+		bn, b = splitNonDigits(b)
+		if c := compareNonDigits(an, bn); c != 0 {
+			return c
+		}
+
+		var ad, bd string
+		ad, a = splitDigits(a)
+		bd, b = splitDigits(b)
+
+		na, _ := strconv.Atoi(ad)
+		nb, _ := strconv.Atoi(bd)
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// compareNonDigits compares two non-digit runs one byte at a time using
+// charOrder, padding the shorter run with the "end of string" sentinel
+// rather than stopping early, since dpkg orders a missing character
+// against the rest of its range rather than treating it as always-least.
+func compareNonDigits(a, b string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var ca, cb byte
+		if i < len(a) {
+			ca = a[i]
+		}
+		if i < len(b) {
+			cb = b[i]
+		}
+		if oa, ob := charOrder(ca), charOrder(cb); oa != ob {
+			if oa < ob {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// charOrder ranks a single byte of a non-digit run the way dpkg's
+// verrevcmp does: '~' sorts lowest of all, then "end of string"
+// (represented by c == 0, the same rank as a digit since both mean "no
+// more non-digit characters here"), then letters in ASCII order, then
+// everything else.
+func charOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case c == 0:
+		return 0
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return int(c)
+	default:
+		return int(c) + 256
+	}
+}
+
+func splitNonDigits(v string) (run, rest string) {
+	i := 0
+	for i < len(v) && (v[i] < '0' || v[i] > '9') {
+		i++
+	}
+	return v[:i], v[i:]
+}
+
+func splitDigits(v string) (run, rest string) {
+	i := 0
+	for i < len(v) && v[i] >= '0' && v[i] <= '9' {
+		i++
+	}
+	return v[:i], v[i:]
+}
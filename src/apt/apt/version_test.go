@@ -0,0 +1,91 @@
+package apt
+
+import "testing"
+
+func TestCompareVersionsTilde(t *testing.T) {
+	// Debian semantics: '~' sorts before everything, including the end of
+	// a version, so a pre-release "~rc1" orders before its final release.
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0~rc1", "1.0", -1},
+		{"1.0", "1.0~rc1", 1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0~", "1.0", -1},
+		{"1.0", "1.0", 0},
+	}
+
+	for _, c := range cases {
+		if got := compareVersions(c.a, c.b); got != c.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestCompareVersionsLetterSuffix(t *testing.T) {
+	// A bare version sorts below the same version with a trailing letter
+	// suffix (e.g. OpenSSL-style "1.1.1" < "1.1.1a" < "1.1.1b").
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.1.1", "1.1.1a", -1},
+		{"1.1.1a", "1.1.1", 1},
+		{"1.1.1a", "1.1.1b", -1},
+	}
+
+	for _, c := range cases {
+		if got := compareVersions(c.a, c.b); got != c.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestCompareVersionsNumericSegments(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.10", -1},
+		{"1.10", "1.9", 1},
+		{"2:1.0", "1:9.0", 1},
+		{"1.0-1", "1.0-2", -1},
+	}
+
+	for _, c := range cases {
+		if got := compareVersions(c.a, c.b); got != c.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestSatisfiesConstraint(t *testing.T) {
+	cases := []struct {
+		installed, field string
+		want             bool
+	}{
+		{"1.2.3", "1.2.3", true},
+		{"1.2.3", ">=1.2.0", true},
+		{"1.2.3", ">=1.3.0", false},
+		{"1.0~rc1", ">=1.0", false},
+		{"1.0", ">=1.0~rc1", true},
+		{"1.2.3", "", true},
+	}
+
+	for _, c := range cases {
+		if got := satisfiesConstraint(c.installed, c.field); got != c.want {
+			t.Errorf("satisfiesConstraint(%q, %q) = %v, want %v", c.installed, c.field, got, c.want)
+		}
+	}
+}
+
+func TestAptGetVersion(t *testing.T) {
+	if got := aptGetVersion(""); got != "" {
+		t.Errorf("aptGetVersion(\"\") = %q, want empty", got)
+	}
+	if got := aptGetVersion(">=1.2.3"); got != "1.2.3" {
+		t.Errorf("aptGetVersion(\">=1.2.3\") = %q, want \"1.2.3\"", got)
+	}
+}